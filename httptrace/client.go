@@ -0,0 +1,105 @@
+package httptrace
+
+import (
+	"net/http"
+	"time"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+// NewClientEvent returns an event which records various aspects of an
+// HTTP request made by a client. It takes an HTTP request as input,
+// and the returned value is incomplete: its Response and
+// ClientSend/ClientRecv values should be set before being logged.
+func NewClientEvent(r *http.Request) *ClientEvent {
+	return &ClientEvent{Request: requestInfo(r)}
+}
+
+// ClientEvent records an HTTP client request event.
+type ClientEvent struct {
+	Request    RequestInfo
+	Response   ResponseInfo
+	ClientSend time.Time
+	ClientRecv time.Time
+}
+
+// Schema returns the constant "HTTPClient".
+func (ClientEvent) Schema() string { return "HTTPClient" }
+
+// Transport is an http.RoundTripper that records outgoing HTTP
+// requests as "HTTPClient"-schema events and propagates the current
+// span to the server by way of Propagators. If the outbound request's
+// context carries an ExternalTraceID (as set by Middleware when it
+// extracted one from an inbound request), it is re-injected too, so a
+// service that received a call from a W3C/B3 mesh and then makes its
+// own downstream calls preserves the upstream trace ID rather than
+// emitting appdash's narrower 64-bit one. Likewise, if the context
+// carries a sampling decision (as set by Middleware after it resolved
+// one for the inbound request), it is forwarded rather than assumed,
+// so a request that was sampled out doesn't force every downstream hop
+// to record anyway; if the context carries no decision (e.g. the
+// request wasn't derived from one Middleware handled), Transport
+// defaults to recording.
+//
+// If Transport is nil, http.DefaultTransport is used.
+type Transport struct {
+	// Recorder is used to record the ClientEvent for each request. It
+	// must be set to a Recorder bound to the span that the request
+	// should be attributed to.
+	Recorder *apptrace.Recorder
+
+	// Propagators are tried, in order, to inject the current span
+	// into the outgoing request's headers. If empty, AppdashPropagator
+	// is used.
+	Propagators Propagators
+
+	// CapturedRequestHeaders and CapturedResponseHeaders list header
+	// names whose values should be recorded as annotations on the
+	// span, in addition to the redacted snapshot already present on
+	// ClientEvent.Request and ClientEvent.Response. Headers not listed
+	// here are not recorded.
+	CapturedRequestHeaders  []string
+	CapturedResponseHeaders []string
+
+	// Transport is the underlying http.RoundTripper used to perform
+	// requests. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	propagators := t.Propagators
+	if len(propagators) == 0 {
+		propagators = Propagators{AppdashPropagator{}}
+	}
+
+	e := NewClientEvent(req)
+	e.ClientSend = time.Now()
+
+	if t.Recorder != nil {
+		ext := ExternalTraceIDFromContext(req.Context())
+		sampled, ok := SampledFromContext(req.Context())
+		if !ok {
+			sampled = true
+		}
+		propagators.Inject(t.Recorder.SpanID, ext, sampled, req.Header)
+	}
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	e.ClientRecv = time.Now()
+	if err != nil {
+		return resp, err
+	}
+
+	e.Response = responseInfo(resp)
+	if t.Recorder != nil {
+		t.Recorder.Annotation(captureHeaders("request", t.CapturedRequestHeaders, req.Header)...)
+		t.Recorder.Annotation(captureHeaders("response", t.CapturedResponseHeaders, resp.Header)...)
+		t.Recorder.Event(e)
+	}
+	return resp, nil
+}
@@ -0,0 +1,30 @@
+package httptrace
+
+import (
+	"net/http"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+// captureHeaders returns one annotation per header in names that is
+// present in h, keyed "http.<kind>.header.<lowercased-name>". Values
+// of multi-valued headers are joined with ", ", per the usual HTTP
+// convention for combining repeated header fields.
+func captureHeaders(kind string, names []string, h http.Header) []apptrace.Annotation {
+	if len(names) == 0 {
+		return nil
+	}
+	var anns []apptrace.Annotation
+	for _, name := range names {
+		vs, ok := h[http.CanonicalHeaderKey(name)]
+		if !ok || len(vs) == 0 {
+			continue
+		}
+		anns = append(anns, apptrace.Annotation{
+			Key:   "http." + kind + ".header." + strings.ToLower(name),
+			Value: []byte(strings.Join(vs, ", ")),
+		})
+	}
+	return anns
+}
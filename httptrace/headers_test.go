@@ -0,0 +1,62 @@
+package httptrace
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCaptureHeaders(t *testing.T) {
+	h := make(http.Header)
+	h.Add("X-Request-Id", "abc")
+	h.Add("X-Forwarded-For", "1.1.1.1")
+	h.Add("X-Forwarded-For", "2.2.2.2")
+	h.Set("X-Unlisted", "should-not-appear")
+
+	anns := captureHeaders("request", []string{"X-Request-Id", "X-Forwarded-For"}, h)
+
+	want := map[string]string{
+		"http.request.header.x-request-id":    "abc",
+		"http.request.header.x-forwarded-for": "1.1.1.1, 2.2.2.2",
+	}
+	if len(anns) != len(want) {
+		t.Fatalf("got %d annotations, want %d: %+v", len(anns), len(want), anns)
+	}
+	for _, a := range anns {
+		wantVal, ok := want[a.Key]
+		if !ok {
+			t.Errorf("unexpected annotation key %q", a.Key)
+			continue
+		}
+		if string(a.Value) != wantVal {
+			t.Errorf("annotation %q = %q, want %q", a.Key, a.Value, wantVal)
+		}
+	}
+}
+
+func TestCaptureHeaders_MissingHeaderSkipped(t *testing.T) {
+	h := make(http.Header)
+	anns := captureHeaders("response", []string{"X-Absent"}, h)
+	if anns != nil {
+		t.Fatalf("got %+v, want nil when none of the listed headers are present", anns)
+	}
+}
+
+func TestCaptureHeaders_NoNamesReturnsNil(t *testing.T) {
+	h := make(http.Header)
+	h.Set("X-Request-Id", "abc")
+	if anns := captureHeaders("request", nil, h); anns != nil {
+		t.Fatalf("got %+v, want nil when names is empty", anns)
+	}
+}
+
+func TestCaptureHeaders_KeyIsLowercasedAndKindPrefixed(t *testing.T) {
+	h := make(http.Header)
+	h.Set("X-Request-Id", "abc")
+	anns := captureHeaders("response", []string{"X-Request-Id"}, h)
+	if len(anns) != 1 {
+		t.Fatalf("got %d annotations, want 1", len(anns))
+	}
+	if want := "http.response.header.x-request-id"; anns[0].Key != want {
+		t.Errorf("key = %q, want %q", anns[0].Key, want)
+	}
+}
@@ -0,0 +1,78 @@
+// Package httpmetrics provides a Prometheus-backed implementation of
+// httptrace.MetricsSink, giving RED (rate/errors/duration) metrics for
+// the same routes that httptrace.Middleware already traces.
+package httpmetrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusSink is an httptrace.MetricsSink that records latency and
+// payload sizes as Prometheus histograms, labeled by route and (for
+// latency) status code.
+//
+// route becomes a Prometheus label value directly, so callers must set
+// MiddlewareConfig.RouteName to a function that returns a bounded set
+// of names (e.g. a router's registered pattern, not the raw path) —
+// otherwise every distinct URI ever requested allocates its own time
+// series, which is the classic unbounded-cardinality mistake.
+type PrometheusSink struct {
+	latency       *prometheus.HistogramVec
+	requestBytes  *prometheus.HistogramVec
+	responseBytes *prometheus.HistogramVec
+}
+
+// NewPrometheusSink creates a PrometheusSink and registers its
+// collectors with reg. namespace is used as the Prometheus metric
+// namespace (e.g. "myapp" yields "myapp_http_server_duration_seconds").
+func NewPrometheusSink(reg prometheus.Registerer, namespace string) *PrometheusSink {
+	s := &PrometheusSink{
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_server",
+			Name:      "duration_seconds",
+			Help:      "HTTP server request duration in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "code"}),
+		requestBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_server",
+			Name:      "request_size_bytes",
+			Help:      "HTTP server request body size in bytes.",
+			Buckets:   prometheus.ExponentialBuckets(64, 8, 6),
+		}, []string{"route"}),
+		responseBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http_server",
+			Name:      "response_size_bytes",
+			Help:      "HTTP server response body size in bytes.",
+			Buckets:   prometheus.ExponentialBuckets(64, 8, 6),
+		}, []string{"route"}),
+	}
+	reg.MustRegister(s.latency, s.requestBytes, s.responseBytes)
+	return s
+}
+
+// ObserveServerLatency implements httptrace.MetricsSink.
+func (s *PrometheusSink) ObserveServerLatency(route string, code int, d time.Duration) {
+	s.latency.WithLabelValues(route, strconv.Itoa(code)).Observe(d.Seconds())
+}
+
+// ObserveRequestBytes implements httptrace.MetricsSink.
+func (s *PrometheusSink) ObserveRequestBytes(route string, n int64) {
+	if n < 0 {
+		return
+	}
+	s.requestBytes.WithLabelValues(route).Observe(float64(n))
+}
+
+// ObserveResponseBytes implements httptrace.MetricsSink.
+func (s *PrometheusSink) ObserveResponseBytes(route string, n int64) {
+	if n < 0 {
+		return
+	}
+	s.responseBytes.WithLabelValues(route).Observe(float64(n))
+}
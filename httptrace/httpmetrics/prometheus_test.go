@@ -0,0 +1,53 @@
+package httpmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusSink_ObserveServerLatency(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewPrometheusSink(reg, "myapp")
+
+	s.ObserveServerLatency("/widgets", 200, 150*time.Millisecond)
+
+	count := testutil.CollectAndCount(s.latency)
+	if count != 1 {
+		t.Fatalf("got %d latency series, want 1", count)
+	}
+}
+
+func TestPrometheusSink_NegativeByteCountsIgnored(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewPrometheusSink(reg, "myapp")
+
+	// ContentLength is -1 when unknown; PrometheusSink must not record
+	// a bogus negative observation for it.
+	s.ObserveRequestBytes("/widgets", -1)
+	s.ObserveResponseBytes("/widgets", -1)
+
+	if got := testutil.CollectAndCount(s.requestBytes); got != 0 {
+		t.Errorf("requestBytes series = %d, want 0 for a negative size", got)
+	}
+	if got := testutil.CollectAndCount(s.responseBytes); got != 0 {
+		t.Errorf("responseBytes series = %d, want 0 for a negative size", got)
+	}
+}
+
+func TestPrometheusSink_ObserveRequestResponseBytes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewPrometheusSink(reg, "myapp")
+
+	s.ObserveRequestBytes("/widgets", 1024)
+	s.ObserveResponseBytes("/widgets", 2048)
+
+	if got := testutil.CollectAndCount(s.requestBytes); got != 1 {
+		t.Errorf("requestBytes series = %d, want 1", got)
+	}
+	if got := testutil.CollectAndCount(s.responseBytes); got != 1 {
+		t.Errorf("responseBytes series = %d, want 1", got)
+	}
+}
@@ -0,0 +1,27 @@
+package httptrace
+
+import "time"
+
+// MetricsSink receives RED (rate/errors/duration) measurements for
+// every request handled by Middleware, in addition to the ServerEvent
+// emitted to the trace collector. This lets operators graph request
+// volume, latency, and payload sizes without having to derive them
+// from traces.
+//
+// route is whatever MiddlewareConfig.RouteName returned, or the raw
+// request URI if RouteName is nil or returned "". Implementations that
+// use route as a metrics label (as PrometheusSink does) should be
+// aware that a nil RouteName therefore produces one label value per
+// distinct URI ever requested — set RouteName in production to avoid
+// unbounded label cardinality.
+type MetricsSink interface {
+	// ObserveServerLatency records the time taken to handle a request
+	// that completed with the given HTTP status code.
+	ObserveServerLatency(route string, code int, d time.Duration)
+
+	// ObserveRequestBytes records the size of a request body.
+	ObserveRequestBytes(route string, n int64)
+
+	// ObserveResponseBytes records the size of a response body.
+	ObserveResponseBytes(route string, n int64)
+}
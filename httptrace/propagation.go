@@ -0,0 +1,371 @@
+package httptrace
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+// ExternalTraceID represents a trace identifier from a foreign tracing
+// system that is wider than apptrace.ID's 64 bits, such as a W3C Trace
+// Context or 128-bit B3 trace ID. Propagator implementations populate
+// it on Extract so that a later Inject of the same context can
+// reconstruct the original upstream ID exactly, rather than the
+// truncated 64-bit value used internally by appdash.
+type ExternalTraceID struct {
+	High uint64 // upper 64 bits; zero for formats that are natively 64-bit
+	Low  uint64 // lower 64 bits; also the value mapped into apptrace.ID
+
+	// TraceState is the raw W3C Trace Context "tracestate" header value,
+	// if one was present at Extract time. It is opaque to appdash and
+	// only understood by W3CTraceContextPropagator, which re-emits it
+	// verbatim on Inject; other Propagators ignore it.
+	TraceState string
+}
+
+type externalTraceIDContextKey struct{}
+
+// ContextWithExternalTraceID returns a copy of ctx carrying ext.
+// Middleware stores the ExternalTraceID it extracts from an inbound
+// request on that request's context this way, so that a later
+// outbound call made through Transport on a request derived from the
+// same context — the common multi-hop case of a service receiving a
+// call and then making its own downstream calls — re-emits the same
+// wide upstream trace ID, rather than just round-tripping it back to
+// the original caller.
+func ContextWithExternalTraceID(ctx context.Context, ext *ExternalTraceID) context.Context {
+	if ext == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, externalTraceIDContextKey{}, ext)
+}
+
+// ExternalTraceIDFromContext returns the ExternalTraceID previously
+// stored by ContextWithExternalTraceID, or nil if ctx doesn't carry
+// one.
+func ExternalTraceIDFromContext(ctx context.Context) *ExternalTraceID {
+	ext, _ := ctx.Value(externalTraceIDContextKey{}).(*ExternalTraceID)
+	return ext
+}
+
+type sampledContextKey struct{}
+
+// ContextWithSampled returns a copy of ctx carrying sampled. Middleware
+// stores the sampling decision it made for an inbound request on that
+// request's context this way, so that a later outbound call made
+// through Transport on a request derived from the same context
+// forwards the same decision, rather than unconditionally recording
+// every downstream hop regardless of whether the request it's part of
+// was actually sampled.
+func ContextWithSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, sampledContextKey{}, sampled)
+}
+
+// SampledFromContext returns the sampling decision previously stored by
+// ContextWithSampled. ok is false if ctx doesn't carry one, in which
+// case sampled should be ignored.
+func SampledFromContext(ctx context.Context) (sampled, ok bool) {
+	sampled, ok = ctx.Value(sampledContextKey{}).(bool)
+	return sampled, ok
+}
+
+// Propagator translates between apptrace's SpanID and the trace
+// context header(s) used by some other tracing system, so that
+// appdash-instrumented services can participate in traces started (or
+// continued) by that system.
+type Propagator interface {
+	// Inject writes spanID and the sampling decision into h. If ext is
+	// non-nil, it is the ExternalTraceID that Extract previously
+	// derived spanID from, and implementations should re-emit it
+	// verbatim so the upstream system sees its own trace ID unchanged.
+	Inject(spanID apptrace.SpanID, ext *ExternalTraceID, sampled bool, h http.Header)
+
+	// Extract reads a span context from h. It returns a nil spanID,
+	// nil ext, nil sampled, and nil error if h does not contain
+	// headers this Propagator understands. sampled is nil if the
+	// headers carry a span context but no sampling decision.
+	Extract(h http.Header) (spanID *apptrace.SpanID, ext *ExternalTraceID, sampled *bool, err error)
+}
+
+// Propagators is a chain of Propagator implementations that are tried,
+// in order, until one successfully extracts a span context. On
+// Inject, it calls every Propagator in the chain so a request can
+// carry span context for several downstream meshes at once.
+type Propagators []Propagator
+
+// Extract tries each Propagator in order and returns the first
+// successfully extracted span context. A Propagator that returns an
+// error (e.g. a malformed header in its format) does not stop the
+// search: the error is remembered and the next Propagator in the
+// chain is still tried, since a different format's headers may be
+// present and well-formed on the same request. If none of them find a
+// span context, it returns nil, nil, nil and the last error seen (nil
+// if no Propagator errored).
+func (p Propagators) Extract(h http.Header) (spanID *apptrace.SpanID, ext *ExternalTraceID, sampled *bool, err error) {
+	var lastErr error
+	for _, prop := range p {
+		spanID, ext, sampled, err = prop.Extract(h)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if spanID != nil {
+			return spanID, ext, sampled, nil
+		}
+	}
+	return nil, nil, nil, lastErr
+}
+
+// Inject calls Inject on every Propagator in the chain.
+func (p Propagators) Inject(spanID apptrace.SpanID, ext *ExternalTraceID, sampled bool, h http.Header) {
+	for _, prop := range p {
+		prop.Inject(spanID, ext, sampled, h)
+	}
+}
+
+// AppdashPropagator is the Propagator for appdash's native Span-ID and
+// Sampled headers. It never populates ExternalTraceID, since
+// apptrace.ID is already its native format.
+type AppdashPropagator struct{}
+
+// Inject implements Propagator.
+func (AppdashPropagator) Inject(spanID apptrace.SpanID, ext *ExternalTraceID, sampled bool, h http.Header) {
+	SetSpanIDHeader(h, spanID)
+	SetSampledHeader(h, sampled)
+}
+
+// Extract implements Propagator.
+func (AppdashPropagator) Extract(h http.Header) (*apptrace.SpanID, *ExternalTraceID, *bool, error) {
+	spanID, err := GetSpanIDHeader(h)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if spanID == nil {
+		return nil, nil, nil, nil
+	}
+	var sampled *bool
+	if v, present := GetSampledHeader(h); present {
+		sampled = &v
+	}
+	return spanID, nil, sampled, nil
+}
+
+// W3CTraceContextPropagator implements the W3C Trace Context
+// (https://www.w3.org/TR/trace-context/) "traceparent" header.
+// "tracestate" is passed through unmodified on Inject when it was
+// present at Extract time, but appdash does not otherwise interpret
+// it.
+type W3CTraceContextPropagator struct{}
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// Inject implements Propagator.
+func (W3CTraceContextPropagator) Inject(spanID apptrace.SpanID, ext *ExternalTraceID, sampled bool, h http.Header) {
+	var traceHi, traceLo uint64
+	if ext != nil {
+		traceHi, traceLo = ext.High, ext.Low
+	} else {
+		traceLo = uint64(spanID.Trace)
+	}
+	flags := "00"
+	if sampled {
+		flags = "01"
+	}
+	h.Set(traceparentHeader, fmt.Sprintf("00-%016x%016x-%016x-%s", traceHi, traceLo, uint64(spanID.Span), flags))
+	if ext != nil && ext.TraceState != "" {
+		h.Set(tracestateHeader, ext.TraceState)
+	}
+}
+
+// Extract implements Propagator.
+func (W3CTraceContextPropagator) Extract(h http.Header) (*apptrace.SpanID, *ExternalTraceID, *bool, error) {
+	v := h.Get(traceparentHeader)
+	if v == "" {
+		return nil, nil, nil, nil
+	}
+	parts := strings.Split(v, "-")
+	if len(parts) != 4 {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent header %q", v)
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	if version != "00" || len(traceID) != 32 || len(parentID) != 16 || len(flags) != 2 {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent header %q", v)
+	}
+
+	traceHi, err := hex.DecodeString(traceID[:16])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent trace-id %q: %s", traceID, err)
+	}
+	traceLo, err := hex.DecodeString(traceID[16:])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent trace-id %q: %s", traceID, err)
+	}
+	parentSpan, err := hex.DecodeString(parentID)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent parent-id %q: %s", parentID, err)
+	}
+	flagsByte, err := hex.DecodeString(flags)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed traceparent flags %q: %s", flags, err)
+	}
+
+	ext := &ExternalTraceID{High: beUint64(traceHi), Low: beUint64(traceLo), TraceState: h.Get(tracestateHeader)}
+	spanID := &apptrace.SpanID{
+		Trace: apptrace.ID(ext.Low),
+		Span:  apptrace.ID(beUint64(parentSpan)),
+	}
+	sampled := flagsByte[0]&0x01 != 0
+	return spanID, ext, &sampled, nil
+}
+
+// B3Propagator implements Zipkin's B3 propagation format, both as the
+// single "b3" header and as the classic "X-B3-*" multi-header form.
+// Extract accepts either form, preferring the single header when both
+// are present. Single controls which form Inject writes.
+type B3Propagator struct {
+	Single bool
+}
+
+const (
+	b3Header           = "b3"
+	b3TraceIDHeader    = "X-B3-TraceId"
+	b3SpanIDHeader     = "X-B3-SpanId"
+	b3ParentSpanHeader = "X-B3-ParentSpanId"
+	b3SampledHeader    = "X-B3-Sampled"
+)
+
+// Inject implements Propagator.
+func (p B3Propagator) Inject(spanID apptrace.SpanID, ext *ExternalTraceID, sampled bool, h http.Header) {
+	traceID := b3TraceIDString(spanID, ext)
+	spanHex := fmt.Sprintf("%016x", uint64(spanID.Span))
+	sampledFlag := "0"
+	if sampled {
+		sampledFlag = "1"
+	}
+	if p.Single {
+		v := traceID + "-" + spanHex + "-" + sampledFlag
+		if spanID.Parent != 0 {
+			v += fmt.Sprintf("-%016x", uint64(spanID.Parent))
+		}
+		h.Set(b3Header, v)
+		return
+	}
+	h.Set(b3TraceIDHeader, traceID)
+	h.Set(b3SpanIDHeader, spanHex)
+	if spanID.Parent != 0 {
+		h.Set(b3ParentSpanHeader, fmt.Sprintf("%016x", uint64(spanID.Parent)))
+	}
+	h.Set(b3SampledHeader, sampledFlag)
+}
+
+func b3TraceIDString(spanID apptrace.SpanID, ext *ExternalTraceID) string {
+	if ext != nil && ext.High != 0 {
+		return fmt.Sprintf("%016x%016x", ext.High, ext.Low)
+	}
+	return fmt.Sprintf("%016x", uint64(spanID.Trace))
+}
+
+// Extract implements Propagator.
+func (B3Propagator) Extract(h http.Header) (*apptrace.SpanID, *ExternalTraceID, *bool, error) {
+	if v := h.Get(b3Header); v != "" {
+		return parseB3Single(v)
+	}
+	traceID := h.Get(b3TraceIDHeader)
+	spanID := h.Get(b3SpanIDHeader)
+	if traceID == "" || spanID == "" {
+		return nil, nil, nil, nil
+	}
+	return parseB3IDs(traceID, spanID, h.Get(b3ParentSpanHeader), parseB3SampledFlag(h.Get(b3SampledHeader)))
+}
+
+func parseB3Single(v string) (*apptrace.SpanID, *ExternalTraceID, *bool, error) {
+	parts := strings.Split(v, "-")
+	if len(parts) < 2 {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 header %q", v)
+	}
+	parent := ""
+	if len(parts) >= 4 {
+		parent = parts[3]
+	}
+	var sampledFlag string
+	if len(parts) >= 3 {
+		sampledFlag = parts[2]
+	}
+	return parseB3IDs(parts[0], parts[1], parent, parseB3SampledFlag(sampledFlag))
+}
+
+// parseB3SampledFlag interprets B3's sampled field, which is "1" or
+// "0", or "d" to request debug (treated the same as sampled=true).
+// It returns nil if the field was absent, meaning no decision was
+// propagated.
+func parseB3SampledFlag(v string) *bool {
+	switch v {
+	case "1", "d":
+		sampled := true
+		return &sampled
+	case "0":
+		sampled := false
+		return &sampled
+	default:
+		return nil
+	}
+}
+
+func parseB3IDs(traceID, spanID, parentID string, sampled *bool) (*apptrace.SpanID, *ExternalTraceID, *bool, error) {
+	var ext *ExternalTraceID
+	var traceLo uint64
+	switch len(traceID) {
+	case 16:
+		b, err := hex.DecodeString(traceID)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 trace id %q: %s", traceID, err)
+		}
+		traceLo = beUint64(b)
+	case 32:
+		hi, err := hex.DecodeString(traceID[:16])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 trace id %q: %s", traceID, err)
+		}
+		lo, err := hex.DecodeString(traceID[16:])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 trace id %q: %s", traceID, err)
+		}
+		traceLo = beUint64(lo)
+		ext = &ExternalTraceID{High: beUint64(hi), Low: traceLo}
+	default:
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 trace id %q", traceID)
+	}
+
+	spanBytes, err := hex.DecodeString(spanID)
+	if err != nil || len(spanID) != 16 {
+		return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 span id %q", spanID)
+	}
+
+	id := apptrace.SpanID{
+		Trace: apptrace.ID(traceLo),
+		Span:  apptrace.ID(beUint64(spanBytes)),
+	}
+	if parentID != "" {
+		parentBytes, err := hex.DecodeString(parentID)
+		if err != nil || len(parentID) != 16 {
+			return nil, nil, nil, fmt.Errorf("httptrace: malformed b3 parent span id %q", parentID)
+		}
+		id.Parent = apptrace.ID(beUint64(parentBytes))
+	}
+	return &id, ext, sampled, nil
+}
+
+func beUint64(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,216 @@
+package httptrace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+func TestW3CTraceContextPropagator_RoundTrip(t *testing.T) {
+	spanID := apptrace.SpanID{Trace: 1, Span: 2}
+	h := make(http.Header)
+	W3CTraceContextPropagator{}.Inject(spanID, nil, true, h)
+
+	got, ext, sampled, err := W3CTraceContextPropagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if got == nil || got.Trace != spanID.Trace {
+		t.Fatalf("got span %+v, want trace %v", got, spanID.Trace)
+	}
+	if ext == nil || ext.High != 0 || ext.Low != uint64(spanID.Trace) {
+		t.Fatalf("got ext %+v, want High=0 Low=%v", ext, uint64(spanID.Trace))
+	}
+	if sampled == nil || !*sampled {
+		t.Fatalf("got sampled %v, want true", sampled)
+	}
+}
+
+func TestW3CTraceContextPropagator_PreservesWideTraceID(t *testing.T) {
+	ext := &ExternalTraceID{High: 0xdeadbeef, Low: 0xcafebabe}
+	spanID := apptrace.SpanID{Trace: apptrace.ID(ext.Low), Span: 2}
+	h := make(http.Header)
+	W3CTraceContextPropagator{}.Inject(spanID, ext, false, h)
+
+	_, gotExt, sampled, err := W3CTraceContextPropagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if gotExt == nil || *gotExt != *ext {
+		t.Fatalf("got ext %+v, want %+v", gotExt, ext)
+	}
+	if sampled == nil || *sampled {
+		t.Fatalf("got sampled %v, want false", sampled)
+	}
+}
+
+func TestW3CTraceContextPropagator_PassesThroughTraceState(t *testing.T) {
+	ext := &ExternalTraceID{High: 1, Low: 2, TraceState: "vendor1=opaqueValue1,vendor2=opaqueValue2"}
+	spanID := apptrace.SpanID{Trace: apptrace.ID(ext.Low), Span: 2}
+	h := make(http.Header)
+	W3CTraceContextPropagator{}.Inject(spanID, ext, true, h)
+
+	if got := h.Get(tracestateHeader); got != ext.TraceState {
+		t.Fatalf("tracestate header = %q, want %q", got, ext.TraceState)
+	}
+
+	_, gotExt, _, err := W3CTraceContextPropagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if gotExt == nil || gotExt.TraceState != ext.TraceState {
+		t.Fatalf("got ext %+v, want TraceState %q", gotExt, ext.TraceState)
+	}
+}
+
+func TestW3CTraceContextPropagator_NoTraceStateNotWritten(t *testing.T) {
+	h := make(http.Header)
+	W3CTraceContextPropagator{}.Inject(apptrace.SpanID{Trace: 1, Span: 2}, nil, true, h)
+	if got := h.Get(tracestateHeader); got != "" {
+		t.Fatalf("tracestate header = %q, want unset when no TraceState was captured", got)
+	}
+}
+
+func TestW3CTraceContextPropagator_NoHeader(t *testing.T) {
+	spanID, ext, sampled, err := W3CTraceContextPropagator{}.Extract(make(http.Header))
+	if spanID != nil || ext != nil || sampled != nil || err != nil {
+		t.Fatalf("got (%v, %v, %v, %v), want all nil", spanID, ext, sampled, err)
+	}
+}
+
+func TestW3CTraceContextPropagator_Malformed(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceparentHeader, "not-a-traceparent")
+	if _, _, _, err := W3CTraceContextPropagator{}.Extract(h); err == nil {
+		t.Fatal("Extract: got nil error for malformed traceparent")
+	}
+}
+
+func TestB3Propagator_RoundTripSingleHeader(t *testing.T) {
+	spanID := apptrace.SpanID{Trace: 1, Span: 2, Parent: 3}
+	h := make(http.Header)
+	B3Propagator{Single: true}.Inject(spanID, nil, true, h)
+
+	got, ext, sampled, err := B3Propagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if got == nil || *got != spanID {
+		t.Fatalf("got span %+v, want %+v", got, spanID)
+	}
+	if ext != nil {
+		t.Fatalf("got ext %+v, want nil for a 64-bit trace id", ext)
+	}
+	if sampled == nil || !*sampled {
+		t.Fatalf("got sampled %v, want true", sampled)
+	}
+}
+
+func TestB3Propagator_RoundTripMultiHeader(t *testing.T) {
+	spanID := apptrace.SpanID{Trace: 1, Span: 2}
+	h := make(http.Header)
+	B3Propagator{Single: false}.Inject(spanID, nil, false, h)
+
+	got, _, sampled, err := B3Propagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if got == nil || *got != spanID {
+		t.Fatalf("got span %+v, want %+v", got, spanID)
+	}
+	if sampled == nil || *sampled {
+		t.Fatalf("got sampled %v, want false", sampled)
+	}
+}
+
+func TestB3Propagator_PreservesWideTraceID(t *testing.T) {
+	ext := &ExternalTraceID{High: 0x1111111111111111, Low: 0x2222222222222222}
+	spanID := apptrace.SpanID{Trace: apptrace.ID(ext.Low), Span: 2}
+	h := make(http.Header)
+	B3Propagator{Single: true}.Inject(spanID, ext, true, h)
+
+	_, gotExt, _, err := B3Propagator{}.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s", err)
+	}
+	if gotExt == nil || *gotExt != *ext {
+		t.Fatalf("got ext %+v, want %+v", gotExt, ext)
+	}
+}
+
+func TestB3Propagator_SampledFlagVariants(t *testing.T) {
+	tests := []struct {
+		flag string
+		want *bool
+	}{
+		{"1", boolPtr(true)},
+		{"d", boolPtr(true)},
+		{"0", boolPtr(false)},
+		{"", nil},
+		{"garbage", nil},
+	}
+	for _, tt := range tests {
+		got := parseB3SampledFlag(tt.flag)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("parseB3SampledFlag(%q) = %v, want %v", tt.flag, got, tt.want)
+		}
+	}
+}
+
+func TestB3Propagator_MalformedMissingSpanID(t *testing.T) {
+	h := make(http.Header)
+	h.Set(b3Header, "notvalidhex")
+	if _, _, _, err := B3Propagator{}.Extract(h); err == nil {
+		t.Fatal("Extract: got nil error for malformed b3 header")
+	}
+}
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestPropagators_ExtractContinuesPastError(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceparentHeader, "garbage")
+	spanID := apptrace.SpanID{Trace: 1, Span: 2}
+	SetSpanIDHeader(h, spanID)
+
+	chain := Propagators{W3CTraceContextPropagator{}, AppdashPropagator{}}
+	got, _, _, err := chain.Extract(h)
+	if err != nil {
+		t.Fatalf("Extract: %s, want the AppdashPropagator's result to win despite the earlier error", err)
+	}
+	if got == nil || *got != spanID {
+		t.Fatalf("got span %+v, want %+v", got, spanID)
+	}
+}
+
+func TestPropagators_ExtractReturnsErrorWhenNoneSucceed(t *testing.T) {
+	h := make(http.Header)
+	h.Set(traceparentHeader, "garbage")
+	chain := Propagators{W3CTraceContextPropagator{}, AppdashPropagator{}}
+	spanID, _, _, err := chain.Extract(h)
+	if spanID != nil {
+		t.Fatalf("got span %+v, want nil", spanID)
+	}
+	if err == nil {
+		t.Fatal("Extract: got nil error, want the traceparent parse error")
+	}
+}
+
+func TestContextExternalTraceID(t *testing.T) {
+	ctx := context.Background()
+	if got := ExternalTraceIDFromContext(ctx); got != nil {
+		t.Fatalf("got %+v, want nil for an empty context", got)
+	}
+
+	ext := &ExternalTraceID{High: 1, Low: 2}
+	ctx = ContextWithExternalTraceID(ctx, ext)
+	if got := ExternalTraceIDFromContext(ctx); got != ext {
+		t.Fatalf("got %+v, want %+v", got, ext)
+	}
+
+	if got := ContextWithExternalTraceID(context.Background(), nil); got != context.Background() {
+		t.Fatalf("ContextWithExternalTraceID with nil ext should return ctx unchanged")
+	}
+}
@@ -0,0 +1,131 @@
+package httptrace
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+// SampledHeader is the HTTP header used to propagate a sampling
+// decision alongside the Span-ID header, so that downstream
+// appdash-instrumented services agree on whether a trace is recorded.
+const SampledHeader = "Sampled"
+
+// GetSampledHeader reads the sampled bit from h. present is false if h
+// does not carry a SampledHeader at all, in which case sampled should
+// be ignored.
+func GetSampledHeader(h http.Header) (sampled, present bool) {
+	v := h.Get(SampledHeader)
+	if v == "" {
+		return false, false
+	}
+	return v == "1", true
+}
+
+// SetSampledHeader writes the sampled bit to h.
+func SetSampledHeader(h http.Header, sampled bool) {
+	if sampled {
+		h.Set(SampledHeader, "1")
+	} else {
+		h.Set(SampledHeader, "0")
+	}
+}
+
+// SamplingDecision is the result of a Sampler's decision of whether to
+// record a request.
+type SamplingDecision struct {
+	// Record is true if the request's span should be recorded.
+	Record bool
+
+	// Priority indicates how strongly the decision should be
+	// respected, for callers that want to distinguish e.g. a
+	// debug-forced sample from a merely probabilistic one. It has no
+	// effect on Record; it is recorded as a "sampling.priority"
+	// annotation when non-zero.
+	Priority int
+}
+
+// Sampler decides whether an inbound request's span should be
+// recorded, based on properties of the request (such as a debug
+// header) and the span ID Middleware has already resolved for it
+// (either extracted from an inbound propagation header, in whatever
+// format, or newly minted for a root span).
+type Sampler func(*http.Request, apptrace.SpanID) SamplingDecision
+
+// AlwaysSample records every request.
+func AlwaysSample(*http.Request, apptrace.SpanID) SamplingDecision {
+	return SamplingDecision{Record: true}
+}
+
+// NeverSample records no requests.
+func NeverSample(*http.Request, apptrace.SpanID) SamplingDecision {
+	return SamplingDecision{Record: false}
+}
+
+// RateLimitingSampler returns a Sampler that records at most qps
+// requests per second, using a token bucket with a burst equal to
+// qps. This bounds the volume of traces recorded regardless of
+// incoming request volume.
+func RateLimitingSampler(qps float64) Sampler {
+	b := &tokenBucket{rate: qps, burst: qps, tokens: qps, last: time.Now()}
+	return func(*http.Request, apptrace.SpanID) SamplingDecision {
+		return SamplingDecision{Record: b.take()}
+	}
+}
+
+type tokenBucket struct {
+	mu                  sync.Mutex
+	rate, burst, tokens float64
+	last                time.Time
+}
+
+func (b *tokenBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ProbabilisticSampler returns a Sampler that records a fraction p
+// (0 <= p <= 1) of requests, chosen by hashing the trace ID rather
+// than rolling dice per-request. Since the hash is a pure function of
+// the trace ID, every appdash-instrumented service that sees the same
+// trace ID reaches the same decision, so a request sampled at the
+// edge stays sampled all the way through the call graph — including
+// when the trace ID was extracted from a non-Appdash propagation
+// format such as W3C traceparent or B3, since Middleware passes in the
+// SpanID it already resolved rather than leaving the sampler to
+// re-extract (and only understand) the native Span-ID header itself.
+func ProbabilisticSampler(p float64) Sampler {
+	return func(r *http.Request, spanID apptrace.SpanID) SamplingDecision {
+		return SamplingDecision{Record: sampleByHash(spanID.Trace, p)}
+	}
+}
+
+func sampleByHash(id apptrace.ID, p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	if p >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	_ = binary.Write(h, binary.BigEndian, uint64(id))
+	return float64(h.Sum64())/float64(math.MaxUint64) < p
+}
@@ -0,0 +1,86 @@
+package httptrace
+
+import (
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+func TestAlwaysNeverSample(t *testing.T) {
+	if !AlwaysSample(nil, apptrace.SpanID{}).Record {
+		t.Error("AlwaysSample: got Record=false")
+	}
+	if NeverSample(nil, apptrace.SpanID{}).Record {
+		t.Error("NeverSample: got Record=true")
+	}
+}
+
+func TestProbabilisticSampler_Bounds(t *testing.T) {
+	spanID := apptrace.SpanID{Trace: 12345}
+	if !ProbabilisticSampler(1)(nil, spanID).Record {
+		t.Error("p=1: got Record=false")
+	}
+	if ProbabilisticSampler(0)(nil, spanID).Record {
+		t.Error("p=0: got Record=true")
+	}
+}
+
+func TestProbabilisticSampler_ConsistentPerTraceID(t *testing.T) {
+	sampler := ProbabilisticSampler(0.5)
+	spanID := apptrace.SpanID{Trace: 42, Span: 1}
+	first := sampler(nil, spanID).Record
+	for i := 0; i < 10; i++ {
+		spanID.Span = apptrace.ID(i)
+		if got := sampler(nil, spanID).Record; got != first {
+			t.Fatalf("sampler(trace=42, span=%d) = %v, want %v (decision should depend only on the trace id)", i, got, first)
+		}
+	}
+}
+
+func TestProbabilisticSampler_ApproximatesFraction(t *testing.T) {
+	sampler := ProbabilisticSampler(0.25)
+	const n = 4000
+	sampled := 0
+	for i := 0; i < n; i++ {
+		if sampler(nil, apptrace.SpanID{Trace: apptrace.ID(i)}).Record {
+			sampled++
+		}
+	}
+	frac := float64(sampled) / n
+	if frac < 0.2 || frac > 0.3 {
+		t.Fatalf("sampled fraction = %v, want roughly 0.25", frac)
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	sampler := RateLimitingSampler(2)
+	if !sampler(nil, apptrace.SpanID{}).Record {
+		t.Fatal("first request: got Record=false, want true (burst should allow it)")
+	}
+	if !sampler(nil, apptrace.SpanID{}).Record {
+		t.Fatal("second request: got Record=false, want true (burst=2)")
+	}
+	if sampler(nil, apptrace.SpanID{}).Record {
+		t.Fatal("third request: got Record=true, want false (burst exhausted)")
+	}
+}
+
+func TestGetSetSampledHeader(t *testing.T) {
+	h := make(http.Header)
+	if _, present := GetSampledHeader(h); present {
+		t.Fatal("GetSampledHeader: got present=true for an unset header")
+	}
+
+	SetSampledHeader(h, true)
+	sampled, present := GetSampledHeader(h)
+	if !present || !sampled {
+		t.Fatalf("got (sampled=%v, present=%v), want (true, true)", sampled, present)
+	}
+
+	SetSampledHeader(h, false)
+	sampled, present = GetSampledHeader(h)
+	if !present || sampled {
+		t.Fatalf("got (sampled=%v, present=%v), want (false, true)", sampled, present)
+	}
+}
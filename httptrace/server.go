@@ -1,8 +1,11 @@
 package httptrace
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"time"
 
 	"sourcegraph.com/sourcegraph/apptrace"
@@ -44,6 +47,13 @@ type ServerEvent struct {
 	User       string
 	ServerRecv time.Time
 	ServerSend time.Time
+
+	// Panicked is true if the handler panicked while serving the
+	// request. Error and Stack are populated from the recovered
+	// panic value in that case.
+	Panicked bool
+	Error    string
+	Stack    string
 }
 
 // Schema returns the constant "HTTPServer".
@@ -53,10 +63,14 @@ func (ServerEvent) Schema() string { return "HTTPServer" }
 // (negroni-compliant) that records incoming HTTP requests to the
 // collector c as "HTTPServer"-schema events.
 func Middleware(c apptrace.Collector, conf *MiddlewareConfig) func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	propagators := conf.Propagators
+	if len(propagators) == 0 {
+		propagators = Propagators{AppdashPropagator{}}
+	}
 	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-		spanID, err := GetSpanIDHeader(r.Header)
+		spanID, ext, sampledFromHeader, err := propagators.Extract(r.Header)
 		if err != nil {
-			log.Printf("Warning: invalid Span-ID header: %s. (Continuing with request handling.)", err)
+			log.Printf("Warning: invalid span header: %s. (Continuing with request handling.)", err)
 		}
 		setSpanIDFromClient := (spanID != nil)
 		if spanID == nil {
@@ -67,6 +81,19 @@ func Middleware(c apptrace.Collector, conf *MiddlewareConfig) func(rw http.Respo
 		if conf.SetContextSpan != nil {
 			conf.SetContextSpan(r, *spanID)
 		}
+		if ext != nil {
+			r = r.WithContext(ContextWithExternalTraceID(r.Context(), ext))
+		}
+
+		var decision SamplingDecision
+		if sampledFromHeader != nil {
+			decision = SamplingDecision{Record: *sampledFromHeader}
+		} else if conf.Sampler != nil {
+			decision = conf.Sampler(r, *spanID)
+		} else {
+			decision = SamplingDecision{Record: true}
+		}
+		r = r.WithContext(ContextWithSampled(r.Context(), decision.Record))
 
 		e := NewServerEvent(r)
 		e.ServerRecv = time.Now()
@@ -77,25 +104,79 @@ func Middleware(c apptrace.Collector, conf *MiddlewareConfig) func(rw http.Respo
 			e.User = conf.CurrentUser(r)
 		}
 
-		rr := &responseInfoRecorder{ResponseWriter: rw}
-		next(rr, r)
-		SetSpanIDHeader(rr.Header(), *spanID)
+		wrapped, rr := newResponseInfoRecorder(rw)
+		panicVal := func() (panicVal interface{}) {
+			defer func() {
+				panicVal = recover()
+				if panicVal != nil {
+					e.Panicked = true
+					e.Error = fmt.Sprint(panicVal)
+					e.Stack = string(debug.Stack())
+				}
+			}()
+			next(wrapped, r)
+			return nil
+		}()
+		if e.Panicked {
+			rr.observeStatusIfUnset(http.StatusInternalServerError)
+		}
+
+		propagators.Inject(*spanID, ext, decision.Record, rr.Header())
+
+		// SetSampledHeader is written unconditionally, independent of
+		// which Propagators are configured, so that the native
+		// SampledHeader guarantee documented on MiddlewareConfig.Sampler
+		// holds even for services that configure only non-Appdash
+		// Propagators (e.g. W3CTraceContextPropagator) to speak to their
+		// mesh, and so appdash-only downstream services still see the
+		// decision.
+		SetSampledHeader(rr.Header(), decision.Record)
 
 		if !setSpanIDFromClient {
 			e.Request = requestInfo(r)
-			log.Printf("e.Request = %+v", e.Request)
-			log.Printf("e.Response = %+v", responseInfo(rr.partialResponse()))
 		}
 		e.Response = responseInfo(rr.partialResponse())
 		e.ServerSend = time.Now()
 
+		// MetricsSink is observed unconditionally, even for requests
+		// whose span is sampled out below: RED metrics are meant to be
+		// cheap enough to cover all traffic, independent of how much
+		// of it is actually traced.
+		if conf.MetricsSink != nil {
+			route := e.Route
+			if route == "" {
+				route = e.Request.URI
+			}
+			conf.MetricsSink.ObserveServerLatency(route, rr.StatusCode(), e.ServerSend.Sub(e.ServerRecv))
+			conf.MetricsSink.ObserveRequestBytes(route, r.ContentLength)
+			conf.MetricsSink.ObserveResponseBytes(route, rr.ContentLength)
+		}
+
+		if !decision.Record && !e.Panicked {
+			return
+		}
+
 		rec := apptrace.NewRecorder(*spanID, c)
 		if e.Route != "" {
 			rec.Name(e.Route)
 		} else {
 			rec.Name(e.Request.URI)
 		}
+		rec.Annotation(captureHeaders("request", conf.CapturedRequestHeaders, r.Header)...)
+		rec.Annotation(captureHeaders("response", conf.CapturedResponseHeaders, rr.Header())...)
+		if decision.Priority != 0 {
+			rec.Annotation(apptrace.Annotation{Key: "sampling.priority", Value: []byte(strconv.Itoa(decision.Priority))})
+		}
+		if conf.ErrorClassifier != nil && e.Response.StatusCode >= 400 {
+			if class := conf.ErrorClassifier(e.Response.StatusCode, rr.Header()); class != "" {
+				rec.Annotation(apptrace.Annotation{Key: "error", Value: []byte(class)})
+			}
+		}
 		rec.Event(e)
+
+		if panicVal != nil {
+			panic(panicVal)
+		}
 	}
 }
 
@@ -114,47 +195,41 @@ type MiddlewareConfig struct {
 	// the HTTP request context, so it may be used by other parts of
 	// the handling process.
 	SetContextSpan func(*http.Request, apptrace.SpanID)
-}
-
-// responseInfoRecorder is an http.ResponseWriter that records a
-// response's HTTP status code and body length and forwards all
-// operations onto an underlying http.ResponseWriter, without
-// buffering the response body.
-type responseInfoRecorder struct {
-	statusCode    int   // HTTP response status code
-	ContentLength int64 // number of bytes written using the Write method
-
-	http.ResponseWriter // underlying ResponseWriter to pass-thru to
-}
-
-// Write always succeeds and writes to r.Body, if not nil.
-func (r *responseInfoRecorder) Write(b []byte) (int, error) {
-	r.ContentLength += int64(len(b))
-	if r.statusCode == 0 {
-		r.statusCode = http.StatusOK
-	}
-	return r.ResponseWriter.Write(b)
-}
 
-func (r *responseInfoRecorder) StatusCode() int {
-	if r.statusCode == 0 {
-		return http.StatusOK
-	}
-	return r.statusCode
+	// Propagators are tried, in order, to extract a span context from
+	// an incoming request's headers, and are all used to inject the
+	// span context into the outgoing response headers. This allows
+	// interoperating with other tracing systems such as OpenTelemetry
+	// (W3CTraceContextPropagator) or Zipkin/Jaeger (B3Propagator). If
+	// empty, only the native AppdashPropagator is used.
+	Propagators Propagators
+
+	// CapturedRequestHeaders and CapturedResponseHeaders list header
+	// names whose values should be recorded as annotations on the
+	// span (e.g. "http.request.header.x-request-id"), in addition to
+	// the redacted snapshot already present on ServerEvent.Request and
+	// ServerEvent.Response. Headers not listed here are not recorded.
+	CapturedRequestHeaders  []string
+	CapturedResponseHeaders []string
+
+	// MetricsSink, if non-nil, is sent latency and payload-size
+	// measurements for every request, alongside the ServerEvent
+	// recorded to the trace collector.
+	MetricsSink MetricsSink
+
+	// Sampler decides whether a request's span should be recorded,
+	// for requests that don't already carry a sampling decision from
+	// upstream (see SampledHeader). If nil, every request is
+	// recorded. The decision is always propagated to the client via
+	// SampledHeader so that downstream appdash-instrumented services
+	// agree.
+	Sampler Sampler
+
+	// ErrorClassifier, if non-nil, is called for every response with
+	// a 4xx or 5xx status code to derive an error class (e.g.
+	// "client_error", "timeout") that is recorded as an "error"
+	// annotation on the span, matching the http.status_code + error
+	// tagging convention used by other tracing systems' HTTP contribs.
+	ErrorClassifier func(status int, headers http.Header) string
 }
 
-// WriteHeader sets r.Code.
-func (r *responseInfoRecorder) WriteHeader(code int) {
-	r.statusCode = code
-	r.ResponseWriter.WriteHeader(code)
-}
-
-// partialResponse constructs a partial response object based on the
-// information it is able to determine about the response.
-func (r *responseInfoRecorder) partialResponse() *http.Response {
-	return &http.Response{
-		StatusCode:    r.StatusCode(),
-		ContentLength: r.ContentLength,
-		Header:        r.Header(),
-	}
-}
\ No newline at end of file
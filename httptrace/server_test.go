@@ -0,0 +1,157 @@
+package httptrace
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/apptrace"
+)
+
+type collectedSpan struct {
+	spanID      apptrace.SpanID
+	annotations []apptrace.Annotation
+}
+
+type fakeCollector struct {
+	mu    sync.Mutex
+	spans []collectedSpan
+}
+
+func (c *fakeCollector) Collect(id apptrace.SpanID, as ...apptrace.Annotation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spans = append(c.spans, collectedSpan{spanID: id, annotations: as})
+	return nil
+}
+
+func (c *fakeCollector) annotation(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, span := range c.spans {
+		for _, a := range span.annotations {
+			if a.Key == key {
+				return string(a.Value), true
+			}
+		}
+	}
+	return "", false
+}
+
+func (c *fakeCollector) called() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.spans) > 0
+}
+
+type fakeMetricsSink struct {
+	mu    sync.Mutex
+	codes []int
+}
+
+func (s *fakeMetricsSink) ObserveServerLatency(route string, code int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes = append(s.codes, code)
+}
+
+func (s *fakeMetricsSink) ObserveRequestBytes(route string, n int64)  {}
+func (s *fakeMetricsSink) ObserveResponseBytes(route string, n int64) {}
+
+func panickingHandler(rw http.ResponseWriter, r *http.Request) {
+	panic("boom")
+}
+
+// runMiddleware invokes mw with a panic-catching next handler and
+// returns the recovered value, or nil if Middleware didn't re-panic.
+func runMiddleware(t *testing.T, mw func(http.ResponseWriter, *http.Request, http.HandlerFunc)) (recovered interface{}) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/widgets/1", nil)
+	rw := httptest.NewRecorder()
+
+	func() {
+		defer func() { recovered = recover() }()
+		mw(rw, req, panickingHandler)
+	}()
+	return recovered
+}
+
+func TestMiddleware_RePanicsAfterRecovering(t *testing.T) {
+	c := &fakeCollector{}
+	mw := Middleware(c, &MiddlewareConfig{})
+
+	got := runMiddleware(t, mw)
+	if got == nil {
+		t.Fatal("Middleware: panic was not re-raised to the caller")
+	}
+	if got != "boom" {
+		t.Fatalf("recovered panic = %v, want %q", got, "boom")
+	}
+}
+
+func TestMiddleware_RecordsPanicDetailsOnServerEvent(t *testing.T) {
+	c := &fakeCollector{}
+	mw := Middleware(c, &MiddlewareConfig{})
+
+	runMiddleware(t, mw)
+
+	if v, ok := c.annotation("HTTPServer.Panicked"); !ok || v != "true" {
+		t.Errorf(`HTTPServer.Panicked = %q (ok=%v), want "true"`, v, ok)
+	}
+	if v, ok := c.annotation("HTTPServer.Error"); !ok || !strings.Contains(v, "boom") {
+		t.Errorf("HTTPServer.Error = %q (ok=%v), want it to contain %q", v, ok, "boom")
+	}
+	if v, ok := c.annotation("HTTPServer.Stack"); !ok || v == "" {
+		t.Errorf("HTTPServer.Stack missing or empty (ok=%v)", ok)
+	}
+}
+
+func TestMiddleware_PanicDefaultsStatusTo500(t *testing.T) {
+	c := &fakeCollector{}
+	sink := &fakeMetricsSink{}
+	mw := Middleware(c, &MiddlewareConfig{MetricsSink: sink})
+
+	runMiddleware(t, mw)
+
+	if len(sink.codes) != 1 || sink.codes[0] != http.StatusInternalServerError {
+		t.Fatalf("observed status codes = %v, want [%d]", sink.codes, http.StatusInternalServerError)
+	}
+}
+
+func TestMiddleware_PanicStillRecordsAndRePanicsWhenSampledOut(t *testing.T) {
+	c := &fakeCollector{}
+	mw := Middleware(c, &MiddlewareConfig{Sampler: NeverSample})
+
+	got := runMiddleware(t, mw)
+	if got == nil {
+		t.Fatal("Middleware: panic was not re-raised even though the sampler would have dropped the span")
+	}
+	if !c.called() {
+		t.Error("Middleware: a panicking request was not recorded despite NeverSample, contradicting the e.Panicked override of the sampling decision")
+	}
+	if v, ok := c.annotation("HTTPServer.Panicked"); !ok || v != "true" {
+		t.Errorf(`HTTPServer.Panicked = %q (ok=%v), want "true"`, v, ok)
+	}
+}
+
+func TestMiddleware_MetricsObservedEvenWhenSampledOut(t *testing.T) {
+	c := &fakeCollector{}
+	sink := &fakeMetricsSink{}
+	mw := Middleware(c, &MiddlewareConfig{Sampler: NeverSample, MetricsSink: sink})
+
+	req := httptest.NewRequest("GET", "/widgets/1", nil)
+	rw := httptest.NewRecorder()
+	mw(rw, req, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if len(sink.codes) != 1 || sink.codes[0] != http.StatusOK {
+		t.Fatalf("observed status codes = %v, want [%d]; MetricsSink must be observed regardless of the sampling decision", sink.codes, http.StatusOK)
+	}
+	if c.called() {
+		t.Error("Collector.Collect was called for a sampled-out, non-panicking request; it should only be observed by MetricsSink, not recorded as a span")
+	}
+}
@@ -0,0 +1,196 @@
+package httptrace
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseInfoRecorder wraps an http.ResponseWriter, recording the
+// status code and number of bytes written on it, without buffering
+// the response body.
+//
+// It deliberately does not implement http.Hijacker, http.Flusher, or
+// http.Pusher itself: embedding http.ResponseWriter the naive way
+// would make the wrapper advertise those interfaces unconditionally
+// (via the promoted methods), breaking type assertions like
+// `_, ok := w.(http.Hijacker)` for writers that don't actually support
+// them, or panic by forwarding to a method the real writer lacks.
+// newResponseInfoRecorder instead returns one of the wrapper types
+// below, each implementing exactly the combination of optional
+// interfaces that the wrapped http.ResponseWriter implements, the
+// same technique used by github.com/felixge/httpsnoop.
+type responseInfoRecorder struct {
+	http.ResponseWriter
+
+	statusCode    int
+	ContentLength int64
+}
+
+// newResponseInfoRecorder wraps w, returning an http.ResponseWriter to
+// pass to the next handler (which implements the same optional
+// interfaces as w) and the *responseInfoRecorder used to read back the
+// recorded status code and byte count.
+func newResponseInfoRecorder(w http.ResponseWriter) (http.ResponseWriter, *responseInfoRecorder) {
+	r := &responseInfoRecorder{ResponseWriter: w}
+
+	_, isHijacker := w.(http.Hijacker)
+	_, isFlusher := w.(http.Flusher)
+	_, isPusher := w.(http.Pusher)
+
+	switch {
+	case isHijacker && isFlusher && isPusher:
+		return &hijackerFlusherPusher{r}, r
+	case isHijacker && isFlusher:
+		return &hijackerFlusher{r}, r
+	case isHijacker && isPusher:
+		return &hijackerPusher{r}, r
+	case isFlusher && isPusher:
+		return &flusherPusher{r}, r
+	case isHijacker:
+		return &hijacker{r}, r
+	case isFlusher:
+		return &flusher{r}, r
+	case isPusher:
+		return &pusher{r}, r
+	default:
+		return r, r
+	}
+}
+
+func (r *responseInfoRecorder) observeWriteHeader(code int) {
+	if r.statusCode == 0 {
+		r.statusCode = code
+	}
+}
+
+// Write always succeeds and writes to r.ResponseWriter.
+func (r *responseInfoRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.ContentLength += int64(n)
+	r.observeWriteHeader(http.StatusOK)
+	return n, err
+}
+
+// WriteHeader sets r.statusCode.
+func (r *responseInfoRecorder) WriteHeader(code int) {
+	r.observeWriteHeader(code)
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// StatusCode returns the recorded status code, defaulting to 200 OK if
+// the handler never called Write or WriteHeader.
+func (r *responseInfoRecorder) StatusCode() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+	return r.statusCode
+}
+
+// observeStatusIfUnset records code as the status, but only if the
+// handler never itself called Write or WriteHeader. It's used to
+// reflect a panicking handler as a 500 rather than the default 200,
+// when the handler panicked before writing anything.
+func (r *responseInfoRecorder) observeStatusIfUnset(code int) {
+	if r.statusCode == 0 {
+		r.statusCode = code
+	}
+}
+
+// partialResponse constructs a partial response object based on the
+// information it is able to determine about the response.
+func (r *responseInfoRecorder) partialResponse() *http.Response {
+	return &http.Response{
+		StatusCode:    r.StatusCode(),
+		ContentLength: r.ContentLength,
+		Header:        r.Header(),
+	}
+}
+
+// hijack records the status appdash associates with a successful
+// protocol upgrade (101 Switching Protocols), since the hijacking
+// handler (e.g. a websocket library) takes over writing the status
+// line itself, and wraps conn/rw so that bytes written on the
+// hijacked connection still count towards ContentLength.
+func (r *responseInfoRecorder) hijack(conn net.Conn, rw *bufio.ReadWriter, err error) (net.Conn, *bufio.ReadWriter, error) {
+	if err != nil {
+		return conn, rw, err
+	}
+	r.observeWriteHeader(http.StatusSwitchingProtocols)
+	cc := &countingConn{Conn: conn, r: r}
+	if rw != nil {
+		rw.Writer = bufio.NewWriter(cc)
+	}
+	return cc, rw, nil
+}
+
+// countingConn wraps a net.Conn obtained from Hijack, adding writes
+// made directly on the connection to r.ContentLength.
+type countingConn struct {
+	net.Conn
+	r *responseInfoRecorder
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.r.ContentLength += int64(n)
+	return n, err
+}
+
+type hijacker struct{ *responseInfoRecorder }
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	return h.hijack(conn, rw, err)
+}
+
+type flusher struct{ *responseInfoRecorder }
+
+func (f *flusher) Flush() { f.ResponseWriter.(http.Flusher).Flush() }
+
+type pusher struct{ *responseInfoRecorder }
+
+func (p *pusher) Push(target string, opts *http.PushOptions) error {
+	return p.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type hijackerFlusher struct{ *responseInfoRecorder }
+
+func (h *hijackerFlusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	return h.hijack(conn, rw, err)
+}
+
+func (h *hijackerFlusher) Flush() { h.ResponseWriter.(http.Flusher).Flush() }
+
+type hijackerPusher struct{ *responseInfoRecorder }
+
+func (h *hijackerPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	return h.hijack(conn, rw, err)
+}
+
+func (h *hijackerPusher) Push(target string, opts *http.PushOptions) error {
+	return h.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type flusherPusher struct{ *responseInfoRecorder }
+
+func (f *flusherPusher) Flush() { f.ResponseWriter.(http.Flusher).Flush() }
+
+func (f *flusherPusher) Push(target string, opts *http.PushOptions) error {
+	return f.ResponseWriter.(http.Pusher).Push(target, opts)
+}
+
+type hijackerFlusherPusher struct{ *responseInfoRecorder }
+
+func (h *hijackerFlusherPusher) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	return h.hijack(conn, rw, err)
+}
+
+func (h *hijackerFlusherPusher) Flush() { h.ResponseWriter.(http.Flusher).Flush() }
+
+func (h *hijackerFlusherPusher) Push(target string, opts *http.PushOptions) error {
+	return h.ResponseWriter.(http.Pusher).Push(target, opts)
+}
@@ -0,0 +1,203 @@
+package httptrace
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// baseResponseWriter is a minimal http.ResponseWriter that implements
+// none of the optional Hijacker/Flusher/Pusher interfaces, used as the
+// embedded base for the combinations below. It deliberately avoids
+// net/http/httptest.ResponseRecorder, which implements Flush itself
+// and would contaminate the interface matrix these tests check.
+type baseResponseWriter struct {
+	header        http.Header
+	statusCode    int
+	body          []byte
+	contentLength int64
+}
+
+func newBaseResponseWriter() *baseResponseWriter {
+	return &baseResponseWriter{header: make(http.Header)}
+}
+
+func (w *baseResponseWriter) Header() http.Header { return w.header }
+
+func (w *baseResponseWriter) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	w.contentLength += int64(len(b))
+	return len(b), nil
+}
+
+func (w *baseResponseWriter) WriteHeader(code int) { w.statusCode = code }
+
+type hijackResponseWriter struct {
+	baseResponseWriter
+	hijacked bool
+}
+
+func (w *hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	server, _ := net.Pipe()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+type flushResponseWriter struct {
+	baseResponseWriter
+	flushed bool
+}
+
+func (w *flushResponseWriter) Flush() { w.flushed = true }
+
+type pushResponseWriter struct {
+	baseResponseWriter
+	pushed bool
+}
+
+func (w *pushResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = true
+	return nil
+}
+
+// fullResponseWriter implements all three optional interfaces.
+type fullResponseWriter struct {
+	baseResponseWriter
+	hijacked, flushed, pushed bool
+}
+
+func (w *fullResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	server, _ := net.Pipe()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+func (w *fullResponseWriter) Flush() { w.flushed = true }
+func (w *fullResponseWriter) Push(target string, opts *http.PushOptions) error {
+	w.pushed = true
+	return nil
+}
+
+func TestNewResponseInfoRecorder_InterfaceMatrix(t *testing.T) {
+	tests := []struct {
+		name         string
+		w            http.ResponseWriter
+		wantHijacker bool
+		wantFlusher  bool
+		wantPusher   bool
+	}{
+		{"plain", newBaseResponseWriter(), false, false, false},
+		{"hijacker", &hijackResponseWriter{baseResponseWriter: *newBaseResponseWriter()}, true, false, false},
+		{"flusher", &flushResponseWriter{baseResponseWriter: *newBaseResponseWriter()}, false, true, false},
+		{"pusher", &pushResponseWriter{baseResponseWriter: *newBaseResponseWriter()}, false, false, true},
+		{"full", &fullResponseWriter{baseResponseWriter: *newBaseResponseWriter()}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped, _ := newResponseInfoRecorder(tt.w)
+
+			_, isHijacker := wrapped.(http.Hijacker)
+			_, isFlusher := wrapped.(http.Flusher)
+			_, isPusher := wrapped.(http.Pusher)
+
+			if isHijacker != tt.wantHijacker {
+				t.Errorf("Hijacker: got %v, want %v", isHijacker, tt.wantHijacker)
+			}
+			if isFlusher != tt.wantFlusher {
+				t.Errorf("Flusher: got %v, want %v", isFlusher, tt.wantFlusher)
+			}
+			if isPusher != tt.wantPusher {
+				t.Errorf("Pusher: got %v, want %v", isPusher, tt.wantPusher)
+			}
+		})
+	}
+}
+
+func TestResponseInfoRecorder_StatusCodeDefaultsTo200(t *testing.T) {
+	_, rr := newResponseInfoRecorder(newBaseResponseWriter())
+	if got := rr.StatusCode(); got != http.StatusOK {
+		t.Errorf("StatusCode() = %d, want %d when nothing was written", got, http.StatusOK)
+	}
+}
+
+func TestResponseInfoRecorder_ObserveStatusIfUnset(t *testing.T) {
+	_, rr := newResponseInfoRecorder(newBaseResponseWriter())
+	rr.observeStatusIfUnset(http.StatusInternalServerError)
+	if got := rr.StatusCode(); got != http.StatusInternalServerError {
+		t.Errorf("StatusCode() = %d, want %d", got, http.StatusInternalServerError)
+	}
+
+	// Once a real status has been observed, observeStatusIfUnset must
+	// not override it.
+	_, rr2 := newResponseInfoRecorder(newBaseResponseWriter())
+	rr2.WriteHeader(http.StatusTeapot)
+	rr2.observeStatusIfUnset(http.StatusInternalServerError)
+	if got := rr2.StatusCode(); got != http.StatusTeapot {
+		t.Errorf("StatusCode() = %d, want %d (already-observed status must win)", got, http.StatusTeapot)
+	}
+}
+
+func TestResponseInfoRecorder_ContentLength(t *testing.T) {
+	wrapped, rr := newResponseInfoRecorder(newBaseResponseWriter())
+	n, err := wrapped.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if n != 5 {
+		t.Fatalf("Write: got n=%d, want 5", n)
+	}
+	if rr.ContentLength != 5 {
+		t.Errorf("ContentLength = %d, want 5", rr.ContentLength)
+	}
+	if got := rr.StatusCode(); got != http.StatusOK {
+		t.Errorf("StatusCode() = %d, want %d after a plain Write", got, http.StatusOK)
+	}
+}
+
+func TestResponseInfoRecorder_HijackCountsBytes(t *testing.T) {
+	w := &hijackResponseWriter{baseResponseWriter: *newBaseResponseWriter()}
+	wrapped, rr := newResponseInfoRecorder(w)
+
+	h, ok := wrapped.(http.Hijacker)
+	if !ok {
+		t.Fatal("wrapped writer does not implement http.Hijacker")
+	}
+	conn, bufrw, err := h.Hijack()
+	if err != nil {
+		t.Fatalf("Hijack: %s", err)
+	}
+	defer conn.Close()
+
+	if !w.hijacked {
+		t.Fatal("underlying ResponseWriter's Hijack was not called")
+	}
+	if got := rr.StatusCode(); got != http.StatusSwitchingProtocols {
+		t.Errorf("StatusCode() = %d, want %d after Hijack", got, http.StatusSwitchingProtocols)
+	}
+
+	n, err := bufrw.Writer.WriteString("ping")
+	if err != nil {
+		t.Fatalf("write on hijacked conn: %s", err)
+	}
+	bufrw.Writer.Flush()
+	if int64(n) != rr.ContentLength {
+		t.Errorf("ContentLength = %d, want %d (bytes written on the hijacked conn)", rr.ContentLength, n)
+	}
+}
+
+func TestResponseInfoRecorder_FlushAndPushDelegate(t *testing.T) {
+	w := &fullResponseWriter{baseResponseWriter: *newBaseResponseWriter()}
+	wrapped, _ := newResponseInfoRecorder(w)
+
+	wrapped.(http.Flusher).Flush()
+	if !w.flushed {
+		t.Error("Flush did not delegate to the underlying ResponseWriter")
+	}
+
+	if err := wrapped.(http.Pusher).Push("/x", nil); err != nil {
+		t.Errorf("Push: %s", err)
+	}
+	if !w.pushed {
+		t.Error("Push did not delegate to the underlying ResponseWriter")
+	}
+}